@@ -19,7 +19,9 @@ import (
 	"github.com/weaveworks/common/httpgrpc"
 
 	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
 	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
 	"github.com/cortexproject/cortex/pkg/util/extract"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
@@ -55,6 +57,11 @@ type StoreConfig struct {
 	// Limits query start time to be greater than now() - MaxLookBackPeriod, if set.
 	MaxLookBackPeriod time.Duration `yaml:"max_look_back_period"`
 
+	// MaxParallelIndexLookups bounds how many per-matcher index lookups a single query
+	// runs concurrently. 0 means unlimited. FetchChunks and label value lookups are not
+	// bounded by it.
+	MaxParallelIndexLookups int `yaml:"max_parallel_index_lookups"`
+
 	// Not visible in yaml because the setting shouldn't be common between ingesters and queriers
 	chunkCacheStubs bool // don't write the full chunk to cache, just a stub entry
 }
@@ -67,6 +74,7 @@ func (cfg *StoreConfig) RegisterFlags(f *flag.FlagSet) {
 
 	f.DurationVar(&cfg.CacheLookupsOlderThan, "store.cache-lookups-older-than", 0, "Cache index entries older than this period. 0 to disable.")
 	f.DurationVar(&cfg.MaxLookBackPeriod, "store.max-look-back-period", 0, "Limit how long back data can be queried")
+	f.IntVar(&cfg.MaxParallelIndexLookups, "store.max-parallel-index-lookups", 100, "Maximum number of per-matcher index lookups to run concurrently for a single query. 0 to disable the limit.")
 
 	// Deprecated.
 	flagext.DeprecatedFlag(f, "store.cardinality-cache-size", "DEPRECATED. Use store.index-cache-read.enable-fifocache and store.index-cache-read.fifocache.size instead.")
@@ -82,21 +90,28 @@ type store struct {
 	schema Schema
 	limits StoreLimits
 	*Fetcher
+
+	// expirationCheckers are consulted, in order, to decide whether a chunk
+	// (or a whole table's interval) is already expired by retention or a
+	// pending tenant delete-request. A nil/empty slice means nothing is
+	// ever pre-filtered this way.
+	expirationCheckers []ExpirationChecker
 }
 
-func newStore(cfg StoreConfig, schema Schema, index IndexClient, chunks Client, limits StoreLimits) (Store, error) {
+func newStore(cfg StoreConfig, schema Schema, index IndexClient, chunks Client, limits StoreLimits, expirationCheckers ...ExpirationChecker) (Store, error) {
 	fetcher, err := NewChunkFetcher(cfg.ChunkCacheConfig, cfg.chunkCacheStubs, chunks)
 	if err != nil {
 		return nil, err
 	}
 
 	return &store{
-		cfg:     cfg,
-		index:   index,
-		chunks:  chunks,
-		schema:  schema,
-		limits:  limits,
-		Fetcher: fetcher,
+		cfg:                cfg,
+		index:              index,
+		chunks:             chunks,
+		schema:             schema,
+		limits:             limits,
+		Fetcher:            fetcher,
+		expirationCheckers: expirationCheckers,
 	}, nil
 }
 
@@ -180,11 +195,57 @@ func (c *store) Get(ctx context.Context, userID string, from, through model.Time
 	}
 
 	log.Span.SetTag("metric", metricName)
-	return c.getMetricNameChunks(ctx, userID, from, through, matchers, metricName)
+	return c.getMetricNameChunks(ctx, userID, from, through, matchers, metricName, nil)
 }
 
-func (c *store) GetChunkRefs(ctx context.Context, userID string, from, through model.Time, allMatchers ...*labels.Matcher) ([][]Chunk, []*Fetcher, error) {
-	return nil, nil, errors.New("not implemented")
+// GetChunkRefs implements Store. It performs the same index lookup as Get,
+// but stops short of fetching chunk bodies: it returns chunk references
+// (external keys + fingerprints) grouped into params.Shard.Of buckets by
+// fingerprint, along with the Fetcher to use for each group, so a
+// query-frontend can dispatch the groups to shard workers that call
+// Fetcher.FetchChunks in parallel. If params.Shard is nil, all chunk refs are
+// returned in a single group.
+func (c *store) GetChunkRefs(ctx context.Context, userID string, from, through model.Time, params SelectParams, allMatchers ...*labels.Matcher) ([][]Chunk, []*Fetcher, error) {
+	log, ctx := spanlogger.New(ctx, "ChunkStore.GetChunkRefs")
+	defer log.Span.Finish()
+	level.Debug(log).Log("from", from, "through", through, "matchers", len(allMatchers))
+
+	// Validate the query is within reasonable bounds.
+	metricName, allMatchers, shortcut, err := c.validateQuery(ctx, userID, &from, &through, allMatchers)
+	if err != nil {
+		return nil, nil, err
+	} else if shortcut {
+		return nil, nil, nil
+	}
+
+	if params.Shard != nil && params.Shard.Of == 0 {
+		return nil, nil, errors.Errorf("invalid shard: Of must be greater than zero, got %d", params.Shard.Of)
+	}
+
+	log.Span.SetTag("metric", metricName)
+	_, matchers := util.SplitFiltersAndMatchers(allMatchers)
+	chunks, err := c.lookupChunksByMetricName(ctx, userID, from, through, matchers, metricName, params.Shard)
+	if err != nil {
+		return nil, nil, err
+	}
+	level.Debug(log).Log("chunks in index", len(chunks))
+
+	// Filter out chunks that are not in the selected time range, and those
+	// that are entirely expired by retention or a pending delete-request.
+	filtered := filterChunksByTime(from, through, chunks)
+	filtered = c.filterExpiredChunks(filtered)
+
+	shards := uint32(1)
+	if params.Shard != nil {
+		shards = params.Shard.Of
+	}
+
+	grouped := groupChunksByShard(filtered, shards)
+	fetchers := make([]*Fetcher, len(grouped))
+	for i := range fetchers {
+		fetchers[i] = c.Fetcher
+	}
+	return grouped, fetchers, nil
 }
 
 // LabelValuesForMetricName retrieves all label values for a single label name and metric name.
@@ -200,7 +261,7 @@ func (c *store) LabelValuesForMetricName(ctx context.Context, userID string, fro
 		return nil, nil
 	}
 
-	queries, err := c.schema.GetReadQueriesForMetricLabel(from, through, userID, metricName, labelName)
+	queries, err := c.schema.GetReadQueriesForMetricLabel(from, through, userID, metricName, labelName, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -234,7 +295,7 @@ func (c *store) LabelNamesForMetricName(ctx context.Context, userID string, from
 		return nil, nil
 	}
 
-	chunks, err := c.lookupChunksByMetricName(ctx, userID, from, through, nil, metricName)
+	chunks, err := c.lookupChunksByMetricName(ctx, userID, from, through, nil, metricName, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -254,6 +315,17 @@ func (c *store) LabelNamesForMetricName(ctx context.Context, userID string, from
 	return labelNamesFromChunks(allChunks), nil
 }
 
+// maxParallelIndexLookups returns the bound on concurrent per-matcher index lookups
+// for userID, preferring a per-tenant override over the store-wide default. This is
+// a distinct knob from MaxQueryParallelism, which bounds the query-frontend's
+// time-based query splitting rather than this fan-out inside the chunk store.
+func (c *store) maxParallelIndexLookups(userID string) int {
+	if max := c.limits.MaxParallelIndexLookups(userID); max > 0 {
+		return max
+	}
+	return c.cfg.MaxParallelIndexLookups
+}
+
 func (c *store) validateQueryTimeRange(ctx context.Context, userID string, from *model.Time, through *model.Time) (bool, error) {
 	//nolint:ineffassign,staticcheck //Leaving ctx even though we don't currently use it, we want to make it available for when we might need it and hopefully will ensure us using the correct context at that time
 	log, ctx := spanlogger.New(ctx, "store.validateQueryTimeRange")
@@ -313,13 +385,13 @@ func (c *store) validateQuery(ctx context.Context, userID string, from *model.Ti
 	return metricNameMatcher.Value, matchers, false, nil
 }
 
-func (c *store) getMetricNameChunks(ctx context.Context, userID string, from, through model.Time, allMatchers []*labels.Matcher, metricName string) ([]Chunk, error) {
+func (c *store) getMetricNameChunks(ctx context.Context, userID string, from, through model.Time, allMatchers []*labels.Matcher, metricName string, shard *Shard) ([]Chunk, error) {
 	log, ctx := spanlogger.New(ctx, "ChunkStore.getMetricNameChunks")
 	defer log.Finish()
 	level.Debug(log).Log("from", from, "through", through, "metricName", metricName, "matchers", len(allMatchers))
 
 	filters, matchers := util.SplitFiltersAndMatchers(allMatchers)
-	chunks, err := c.lookupChunksByMetricName(ctx, userID, from, through, matchers, metricName)
+	chunks, err := c.lookupChunksByMetricName(ctx, userID, from, through, matchers, metricName, shard)
 	if err != nil {
 		return nil, err
 	}
@@ -329,6 +401,10 @@ func (c *store) getMetricNameChunks(ctx context.Context, userID string, from, th
 	filtered := filterChunksByTime(from, through, chunks)
 	level.Debug(log).Log("Chunks post filtering", len(chunks))
 
+	// Filter out chunks that are entirely expired by retention or a pending
+	// delete-request before we pay for a FetchChunks round trip to object storage.
+	filtered = c.filterExpiredChunks(filtered)
+
 	maxChunksPerQuery := c.limits.MaxChunksPerQuery(userID)
 	if maxChunksPerQuery > 0 && len(filtered) > maxChunksPerQuery {
 		err := httpgrpc.Errorf(http.StatusBadRequest, "Query %v fetched too many chunks (%d > %d)", allMatchers, len(filtered), maxChunksPerQuery)
@@ -348,13 +424,13 @@ func (c *store) getMetricNameChunks(ctx context.Context, userID string, from, th
 	return filteredChunks, nil
 }
 
-func (c *store) lookupChunksByMetricName(ctx context.Context, userID string, from, through model.Time, matchers []*labels.Matcher, metricName string) ([]Chunk, error) {
+func (c *store) lookupChunksByMetricName(ctx context.Context, userID string, from, through model.Time, matchers []*labels.Matcher, metricName string, shard *Shard) ([]Chunk, error) {
 	log, ctx := spanlogger.New(ctx, "ChunkStore.lookupChunksByMetricName")
 	defer log.Finish()
 
 	// Just get chunks for metric if there are no matchers
 	if len(matchers) == 0 {
-		queries, err := c.schema.GetReadQueriesForMetric(from, through, userID, metricName)
+		queries, err := c.schema.GetReadQueriesForMetric(from, through, userID, metricName, shard)
 		if err != nil {
 			return nil, err
 		}
@@ -375,62 +451,61 @@ func (c *store) lookupChunksByMetricName(ctx context.Context, userID string, fro
 		return c.convertChunkIDsToChunks(ctx, userID, chunkIDs)
 	}
 
-	// Otherwise get chunks which include other matchers
-	incomingChunkIDs := make(chan []string)
-	incomingErrors := make(chan error)
-	for _, matcher := range matchers {
-		go func(matcher *labels.Matcher) {
-			// Lookup IndexQuery's
-			var queries []IndexQuery
-			var err error
-			if matcher.Type != labels.MatchEqual {
-				queries, err = c.schema.GetReadQueriesForMetricLabel(from, through, userID, metricName, matcher.Name)
-			} else {
-				queries, err = c.schema.GetReadQueriesForMetricLabelValue(from, through, userID, metricName, matcher.Name, matcher.Value)
-			}
-			if err != nil {
-				incomingErrors <- err
-				return
-			}
-			level.Debug(log).Log("matcher", matcher, "queries", len(queries))
+	// Otherwise get chunks which include other matchers. Fan out per-matcher lookups
+	// across a bounded worker pool, instead of one unbounded goroutine per matcher,
+	// so a query with many matchers can't exhaust parallelism control, and so that
+	// ctx cancellation is honoured instead of leaking goroutines stuck writing to an
+	// unbuffered channel no one is still reading from.
+	perMatcherChunkIDs := make([][]string, len(matchers))
+	parallelism := c.maxParallelIndexLookups(userID)
+	if parallelism <= 0 {
+		// 0 means no limit: look up every matcher at once.
+		parallelism = len(matchers)
+	}
+	err := concurrency.ForEachJob(ctx, len(matchers), parallelism, func(ctx context.Context, i int) error {
+		matcher := matchers[i]
+
+		// Lookup IndexQuery's
+		var queries []IndexQuery
+		var err error
+		if matcher.Type != labels.MatchEqual {
+			queries, err = c.schema.GetReadQueriesForMetricLabel(from, through, userID, metricName, matcher.Name, shard)
+		} else {
+			queries, err = c.schema.GetReadQueriesForMetricLabelValue(from, through, userID, metricName, matcher.Name, matcher.Value, shard)
+		}
+		if err != nil {
+			return err
+		}
+		level.Debug(log).Log("matcher", matcher, "queries", len(queries))
 
-			// Lookup IndexEntry's
-			entries, err := c.lookupEntriesByQueries(ctx, queries)
-			if err != nil {
-				incomingErrors <- err
-				return
-			}
-			level.Debug(log).Log("matcher", matcher, "entries", len(entries))
+		// Lookup IndexEntry's
+		entries, err := c.lookupEntriesByQueries(ctx, queries)
+		if err != nil {
+			return err
+		}
+		level.Debug(log).Log("matcher", matcher, "entries", len(entries))
 
-			// Convert IndexEntry's to chunk IDs, filter out non-matchers at the same time.
-			chunkIDs, err := c.parseIndexEntries(ctx, entries, matcher)
-			if err != nil {
-				incomingErrors <- err
-				return
-			}
-			level.Debug(log).Log("matcher", matcher, "chunkIDs", len(chunkIDs))
-			incomingChunkIDs <- chunkIDs
-		}(matcher)
+		// Convert IndexEntry's to chunk IDs, filter out non-matchers at the same time.
+		chunkIDs, err := c.parseIndexEntries(ctx, entries, matcher)
+		if err != nil {
+			return err
+		}
+		level.Debug(log).Log("matcher", matcher, "chunkIDs", len(chunkIDs))
+		perMatcherChunkIDs[i] = chunkIDs
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Receive chunkSets from all matchers
 	var chunkIDs []string
-	var lastErr error
-	for i := 0; i < len(matchers); i++ {
-		select {
-		case incoming := <-incomingChunkIDs:
-			if chunkIDs == nil {
-				chunkIDs = incoming
-			} else {
-				chunkIDs = intersectStrings(chunkIDs, incoming)
-			}
-		case err := <-incomingErrors:
-			lastErr = err
+	for _, incoming := range perMatcherChunkIDs {
+		if chunkIDs == nil {
+			chunkIDs = incoming
+		} else {
+			chunkIDs = intersectStrings(chunkIDs, incoming)
 		}
 	}
-	if lastErr != nil {
-		return nil, lastErr
-	}
 	level.Debug(log).Log("msg", "post intersection", "chunkIDs", len(chunkIDs))
 
 	// Convert IndexEntry's into chunks
@@ -482,6 +557,39 @@ func (c *store) parseIndexEntries(ctx context.Context, entries []IndexEntry, mat
 	return result, nil
 }
 
+// filterExpiredChunks drops chunks whose entire [From,Through] is already
+// expired according to any configured ExpirationChecker, so that the caller
+// doesn't fetch chunk bodies that would just be discarded anyway.
+func (c *store) filterExpiredChunks(chunks []Chunk) []Chunk {
+	if len(c.expirationCheckers) == 0 {
+		return chunks
+	}
+
+	now := model.Now()
+	filtered := make([]Chunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		entry := ChunkEntry{
+			UserID:  chunk.UserID,
+			Labels:  chunk.Metric,
+			ChunkID: chunk.ExternalKey(),
+			From:    chunk.From,
+			Through: chunk.Through,
+		}
+
+		fullyExpired := false
+		for _, checker := range c.expirationCheckers {
+			if expired, nonExpired := checker.Expired(entry, now); expired && len(nonExpired) == 0 {
+				fullyExpired = true
+				break
+			}
+		}
+		if !fullyExpired {
+			filtered = append(filtered, chunk)
+		}
+	}
+	return filtered
+}
+
 func (c *store) convertChunkIDsToChunks(ctx context.Context, userID string, chunkIDs []string) ([]Chunk, error) {
 	chunkSet := make([]Chunk, 0, len(chunkIDs))
 	for _, chunkID := range chunkIDs {
@@ -495,7 +603,7 @@ func (c *store) convertChunkIDsToChunks(ctx context.Context, userID string, chun
 	return chunkSet, nil
 }
 
-func (c *store) DeleteChunk(ctx context.Context, from, through model.Time, userID, chunkID string, metric labels.Labels, partiallyDeletedInterval *model.Interval) error {
+func (c *store) DeleteChunk(ctx context.Context, from, through model.Time, userID, chunkID string, metric labels.Labels, partiallyDeletedInterval *model.Interval, filter func(ts model.Time, sample model.SamplePair, lbls labels.Labels) bool) error {
 	metricName := metric.Get(model.MetricNameLabel)
 	if metricName == "" {
 		return ErrMetricNameLabelMissing
@@ -506,7 +614,7 @@ func (c *store) DeleteChunk(ctx context.Context, from, through model.Time, userI
 		return errors.Wrapf(err, "when getting index entries to delete for chunkID=%s", chunkID)
 	}
 
-	return c.deleteChunk(ctx, userID, chunkID, metric, chunkWriteEntries, partiallyDeletedInterval, func(chunk Chunk) error {
+	return c.deleteChunk(ctx, userID, chunkID, metric, chunkWriteEntries, partiallyDeletedInterval, filter, func(chunk Chunk) error {
 		return c.PutOne(ctx, chunk.From, chunk.Through, chunk)
 	})
 }
@@ -517,6 +625,7 @@ func (c *store) deleteChunk(ctx context.Context,
 	metric labels.Labels,
 	chunkWriteEntries []IndexEntry,
 	partiallyDeletedInterval *model.Interval,
+	filter func(ts model.Time, sample model.SamplePair, lbls labels.Labels) bool,
 	putChunkFunc func(chunk Chunk) error) error {
 
 	metricName := metric.Get(model.MetricNameLabel)
@@ -524,9 +633,10 @@ func (c *store) deleteChunk(ctx context.Context,
 		return ErrMetricNameLabelMissing
 	}
 
-	// if chunk is partially deleted, fetch it, slice non-deleted portion and put it to store before deleting original chunk
-	if partiallyDeletedInterval != nil {
-		err := c.reboundChunk(ctx, userID, chunkID, *partiallyDeletedInterval, putChunkFunc)
+	// if chunk is partially deleted (by time range, or by a row-level filter), fetch it,
+	// rebuild the surviving portion and put it to store before deleting original chunk
+	if partiallyDeletedInterval != nil || filter != nil {
+		err := c.reboundChunk(ctx, userID, chunkID, partiallyDeletedInterval, filter, putChunkFunc)
 		if err != nil {
 			return errors.Wrapf(err, "chunkID=%s", chunkID)
 		}
@@ -553,13 +663,20 @@ func (c *store) deleteChunk(ctx context.Context,
 	return nil
 }
 
-func (c *store) reboundChunk(ctx context.Context, userID, chunkID string, partiallyDeletedInterval model.Interval, putChunkFunc func(chunk Chunk) error) error {
+// reboundChunk fetches the chunk for chunkID and rebuilds it to contain only the
+// samples that should survive a delete. If filter is non-nil it takes precedence
+// and the chunk is rebuilt sample-by-sample, keeping everything filter returns
+// false for; this supports row-level (series/value-predicate) erasure rather than
+// a blunt time-range drop. Otherwise partiallyDeletedInterval is used to slice the
+// chunk on its time boundaries, as before. Either way, resulting chunks (if any
+// samples survive) are encoded and handed to putChunkFunc.
+func (c *store) reboundChunk(ctx context.Context, userID, chunkID string, partiallyDeletedInterval *model.Interval, filter func(ts model.Time, sample model.SamplePair, lbls labels.Labels) bool, putChunkFunc func(chunk Chunk) error) error {
 	chunk, err := ParseExternalKey(userID, chunkID)
 	if err != nil {
 		return errors.Wrap(err, "when parsing external key")
 	}
 
-	if !intervalsOverlap(model.Interval{Start: chunk.From, End: chunk.Through}, partiallyDeletedInterval) {
+	if filter == nil && partiallyDeletedInterval != nil && !intervalsOverlap(model.Interval{Start: chunk.From, End: chunk.Through}, *partiallyDeletedInterval) {
 		return ErrParialDeleteChunkNoOverlap
 	}
 
@@ -576,26 +693,37 @@ func (c *store) reboundChunk(ctx context.Context, userID, chunkID string, partia
 	}
 
 	chunk = chunks[0]
+
 	var newChunks []*Chunk
-	if partiallyDeletedInterval.Start > chunk.From {
-		newChunk, err := chunk.Slice(chunk.From, partiallyDeletedInterval.Start-1)
-		if err != nil && err != ErrSliceNoDataInRange {
-			return errors.Wrapf(err, "when slicing chunk for interval %d - %d", chunk.From, partiallyDeletedInterval.Start-1)
+	if filter != nil {
+		newChunk, err := filterChunkSamples(chunk, filter)
+		if err != nil {
+			return errors.Wrapf(err, "when filtering samples for chunkID=%s", chunkID)
 		}
-
 		if newChunk != nil {
 			newChunks = append(newChunks, newChunk)
 		}
-	}
+	} else {
+		if partiallyDeletedInterval.Start > chunk.From {
+			newChunk, err := chunk.Slice(chunk.From, partiallyDeletedInterval.Start-1)
+			if err != nil && err != ErrSliceNoDataInRange {
+				return errors.Wrapf(err, "when slicing chunk for interval %d - %d", chunk.From, partiallyDeletedInterval.Start-1)
+			}
 
-	if partiallyDeletedInterval.End < chunk.Through {
-		newChunk, err := chunk.Slice(partiallyDeletedInterval.End+1, chunk.Through)
-		if err != nil && err != ErrSliceNoDataInRange {
-			return errors.Wrapf(err, "when slicing chunk for interval %d - %d", partiallyDeletedInterval.End+1, chunk.Through)
+			if newChunk != nil {
+				newChunks = append(newChunks, newChunk)
+			}
 		}
 
-		if newChunk != nil {
-			newChunks = append(newChunks, newChunk)
+		if partiallyDeletedInterval.End < chunk.Through {
+			newChunk, err := chunk.Slice(partiallyDeletedInterval.End+1, chunk.Through)
+			if err != nil && err != ErrSliceNoDataInRange {
+				return errors.Wrapf(err, "when slicing chunk for interval %d - %d", partiallyDeletedInterval.End+1, chunk.Through)
+			}
+
+			if newChunk != nil {
+				newChunks = append(newChunks, newChunk)
+			}
 		}
 	}
 
@@ -613,7 +741,43 @@ func (c *store) reboundChunk(ctx context.Context, userID, chunkID string, partia
 	return nil
 }
 
-func (c *store) DeleteSeriesIDs(ctx context.Context, from, through model.Time, userID string, metric labels.Labels) error {
+// filterChunkSamples rebuilds chunk keeping only the samples for which filter
+// returns false, bounded by the first and last kept sample. It returns a nil
+// chunk (and no error) if every sample was filtered out, so the caller can
+// treat the chunk as fully deleted.
+func filterChunkSamples(chunk Chunk, filter func(ts model.Time, sample model.SamplePair, lbls labels.Labels) bool) (*Chunk, error) {
+	it := chunk.Data.NewIterator(nil)
+	newData := encoding.New()
+
+	var kept int
+	var from, through model.Time
+	for it.Scan() {
+		sample := it.Value()
+		if filter(sample.Timestamp, sample, chunk.Metric) {
+			continue
+		}
+
+		if _, err := newData.Add(sample); err != nil {
+			return nil, errors.Wrap(err, "when rebuilding chunk from filtered samples")
+		}
+		if kept == 0 {
+			from = sample.Timestamp
+		}
+		through = sample.Timestamp
+		kept++
+	}
+	if err := it.Err(); err != nil {
+		return nil, errors.Wrap(err, "when iterating chunk samples for filtering")
+	}
+	if kept == 0 {
+		return nil, nil
+	}
+
+	newChunk := NewChunk(chunk.UserID, chunk.Fingerprint, chunk.Metric, newData, from, through)
+	return &newChunk, nil
+}
+
+func (c *store) DeleteSeriesIDs(ctx context.Context, from, through model.Time, userID string, metric labels.Labels, filter func(ts model.Time, sample model.SamplePair, lbls labels.Labels) bool) error {
 	// SeriesID is something which is only used in SeriesStore so we need not do anything here
 	return nil
 }
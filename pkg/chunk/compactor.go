@@ -0,0 +1,222 @@
+package chunk
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/cortexproject/cortex/pkg/util/spanlogger"
+)
+
+// CompactorConfig configures the retention/deletion Compactor.
+type CompactorConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	CompactionInterval time.Duration `yaml:"compaction_interval"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *CompactorConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "compactor.enabled", false, "Enables the retention/deletion compactor.")
+	f.DurationVar(&cfg.CompactionInterval, "compactor.compaction-interval", 10*time.Minute, "The frequency at which tables are scanned for expired chunks.")
+}
+
+// Compactor is a background service that walks index tables, and for each
+// table that can possibly contain expired data (per ExpirationChecker),
+// batches up the deletion of expired chunks instead of requiring one
+// DeleteChunk RPC per chunk from an external caller.
+type Compactor struct {
+	services.Service
+
+	cfg     CompactorConfig
+	tables  TableClient
+	store   *store
+	checker ExpirationChecker
+
+	tablesSkipped  prometheus.Counter
+	tablesScanned  prometheus.Counter
+	chunksDeleted  prometheus.Counter
+	chunksRebounds prometheus.Counter
+}
+
+// TableClient lists the periodic index tables a Compactor should consider.
+type TableClient interface {
+	ListTables(ctx context.Context) ([]string, error)
+}
+
+// NewCompactor makes a new Compactor. s must be the chunk store returned by
+// NewStore, since the compactor needs access to the store's index client,
+// schema and (for partial deletes) the chunk fetcher.
+func NewCompactor(cfg CompactorConfig, tables TableClient, s Store, checker ExpirationChecker) (*Compactor, error) {
+	st, ok := s.(*store)
+	if !ok {
+		return nil, errors.New("compactor requires a chunk store")
+	}
+
+	c := &Compactor{
+		cfg:     cfg,
+		tables:  tables,
+		store:   st,
+		checker: checker,
+		tablesSkipped: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "compactor_tables_skipped_total",
+			Help:      "Number of index tables skipped because they cannot contain expired chunks.",
+		}),
+		tablesScanned: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "compactor_tables_scanned_total",
+			Help:      "Number of index tables scanned for expired chunks.",
+		}),
+		chunksDeleted: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "compactor_chunks_deleted_total",
+			Help:      "Number of chunks deleted by the compactor.",
+		}),
+		chunksRebounds: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "compactor_chunks_rebounded_total",
+			Help:      "Number of chunks partially deleted (rebounded) by the compactor.",
+		}),
+	}
+
+	c.Service = services.NewTimerService(cfg.CompactionInterval, c.runCompaction, c.runCompaction, nil)
+	return c, nil
+}
+
+func (c *Compactor) runCompaction(ctx context.Context) error {
+	log, ctx := spanlogger.New(ctx, "Compactor.runCompaction")
+	defer log.Span.Finish()
+
+	tables, err := c.tables.ListTables(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing tables")
+	}
+
+	for _, tableName := range tables {
+		interval := intervalForTableName(tableName)
+		if c.checker != nil && !c.checker.IntervalHasExpiredChunks(interval) {
+			c.tablesSkipped.Inc()
+			continue
+		}
+
+		c.tablesScanned.Inc()
+		if err := c.compactTable(ctx, tableName); err != nil {
+			level.Error(util.WithContext(ctx, util.Logger)).Log("msg", "failed to compact table", "table", tableName, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// compactTable scans a single table's index entries and, for every chunk
+// that the ExpirationChecker considers expired, batches up its index and
+// object storage deletion (or, for partially-expired chunks, rebounds it
+// to a new chunk covering only the surviving interval) so a whole table
+// can be processed with a single pass instead of one DeleteChunk RPC per
+// chunk.
+func (c *Compactor) compactTable(ctx context.Context, tableName string) error {
+	now := model.Now()
+
+	var toDelete []Chunk
+	err := c.store.index.QueryPages(ctx, []IndexQuery{{TableName: tableName}}, func(query IndexQuery, resp ReadBatch) bool {
+		iter := resp.Iterator()
+		for iter.Next() {
+			chunkKey, _, _, perr := parseChunkTimeRangeValue(iter.RangeValue(), iter.Value())
+			if perr != nil {
+				continue
+			}
+
+			chunk, perr := ParseExternalKey("", chunkKey)
+			if perr != nil {
+				continue
+			}
+
+			toDelete = append(toDelete, chunk)
+		}
+		return true
+	})
+	if err != nil {
+		return errors.Wrap(err, "scanning table")
+	}
+
+	// Batch all of this table's index deletes into a single write, so a
+	// table with thousands of expired chunks costs one BatchWrite instead
+	// of one per chunk.
+	batch := c.store.index.NewWriteBatch()
+	for _, chunk := range toDelete {
+		entry := ChunkEntry{
+			TableName: tableName,
+			UserID:    chunk.UserID,
+			Labels:    chunk.Metric,
+			ChunkID:   chunk.ExternalKey(),
+			From:      chunk.From,
+			Through:   chunk.Through,
+		}
+
+		expired, nonExpired := c.checker.Expired(entry, now)
+		if !expired {
+			continue
+		}
+
+		if len(nonExpired) > 0 {
+			// nonExpired is the set of sub-intervals the chunk must keep, which
+			// can be disjoint (eg. a delete request carving a hole out of the
+			// middle of the chunk). reboundChunk's partiallyDeletedInterval
+			// form only supports cutting a single contiguous range out of the
+			// chunk, so route this through its per-sample filter instead,
+			// keeping exactly the samples that fall in one of nonExpired.
+			if err := c.store.reboundChunk(ctx, entry.UserID, entry.ChunkID, nil, keepOnlyIntervals(nonExpired), func(newChunk Chunk) error {
+				return c.store.PutOne(ctx, newChunk.From, newChunk.Through, newChunk)
+			}); err != nil {
+				// The chunk still has data that must survive (nonExpired), and
+				// we failed to write it out under a new chunk ID. Deleting the
+				// original below would lose that data for good, so leave the
+				// original in place and pick this chunk back up next compaction.
+				level.Warn(util.WithContext(ctx, util.Logger)).Log("msg", "failed to rebound chunk", "chunk", entry.ChunkID, "err", err)
+				continue
+			}
+			c.chunksRebounds.Inc()
+		}
+
+		writeEntries, err := c.store.schema.GetWriteEntries(entry.From, entry.Through, entry.UserID, entry.Labels.Get(model.MetricNameLabel), entry.Labels, entry.ChunkID)
+		if err != nil {
+			level.Warn(util.WithContext(ctx, util.Logger)).Log("msg", "failed to compute index entries for expired chunk", "chunk", entry.ChunkID, "err", err)
+			continue
+		}
+		for _, e := range writeEntries {
+			batch.Delete(e.TableName, e.HashValue, e.RangeValue)
+		}
+
+		if err := c.store.chunks.DeleteChunk(ctx, entry.ChunkID); err != nil && err != ErrStorageObjectNotFound {
+			level.Warn(util.WithContext(ctx, util.Logger)).Log("msg", "failed to delete chunk from storage", "chunk", entry.ChunkID, "err", err)
+			continue
+		}
+		c.chunksDeleted.Inc()
+	}
+
+	return c.store.index.BatchWrite(ctx, batch)
+}
+
+// keepOnlyIntervals returns a reboundChunk filter (which reports true for
+// samples to *drop*, per DeleteChunk's convention) that drops every sample
+// whose timestamp doesn't fall inside one of kept, the surviving
+// sub-intervals an ExpirationChecker reported for a partially-expired chunk.
+func keepOnlyIntervals(kept []model.Interval) func(ts model.Time, sample model.SamplePair, lbls labels.Labels) bool {
+	return func(ts model.Time, _ model.SamplePair, _ labels.Labels) bool {
+		for _, interval := range kept {
+			if ts >= interval.Start && ts <= interval.End {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,219 @@
+package chunk
+
+import (
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// compositeExpirationChecker ORs together any number of ExpirationCheckers: a
+// table or chunk is considered expired as soon as any one of them says so.
+// When a chunk is only partially expired, the surviving intervals reported
+// by every checker that found it expired are intersected together, so a
+// chunk that e.g. outlives retention but has a delete-request carved out of
+// its middle keeps only what both checkers agree should survive. This is how
+// retention (age-based) and tenant delete-requests are combined into the
+// single ExpirationChecker the store and Compactor consult.
+type compositeExpirationChecker struct {
+	checkers []ExpirationChecker
+}
+
+// NewCompositeExpirationChecker ORs together the given checkers.
+func NewCompositeExpirationChecker(checkers ...ExpirationChecker) ExpirationChecker {
+	return &compositeExpirationChecker{checkers: checkers}
+}
+
+func (c *compositeExpirationChecker) IntervalHasExpiredChunks(interval model.Interval) bool {
+	for _, checker := range c.checkers {
+		if checker.IntervalHasExpiredChunks(interval) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compositeExpirationChecker) Expired(chunkRef ChunkEntry, now model.Time) (bool, []model.Interval) {
+	expired := false
+	// survivors tracks what the whole chunk has left to keep once every
+	// expired-reporting checker's cuts are applied. A nil checker result
+	// means "expired in full", which only ever shrinks survivors further, so
+	// start from the whole chunk and intersect each checker's nonExpired
+	// intervals into it in turn.
+	survivors := []model.Interval{{Start: chunkRef.From, End: chunkRef.Through}}
+
+	for _, checker := range c.checkers {
+		checkerExpired, nonExpired := checker.Expired(chunkRef, now)
+		if !checkerExpired {
+			continue
+		}
+		expired = true
+		survivors = intersectIntervals(survivors, nonExpired)
+		if len(survivors) == 0 {
+			return true, nil
+		}
+	}
+
+	if !expired {
+		return false, nil
+	}
+	if len(survivors) == 1 && survivors[0].Start == chunkRef.From && survivors[0].End == chunkRef.Through {
+		// Nothing was actually cut: report the chunk as expired in full
+		// rather than as "partially expired, keep everything".
+		return true, nil
+	}
+	return true, survivors
+}
+
+// StreamRetentionRule overrides the tenant's default retention period for
+// chunks whose labels match every one of Matchers.
+type StreamRetentionRule struct {
+	Matchers []*labels.Matcher
+	Period   time.Duration
+}
+
+// retentionExpirationChecker is an ExpirationChecker backed by a per-tenant
+// retention period (StoreLimits.RetentionPeriod), with optional per-stream
+// overrides (StoreLimits.StreamRetention).
+type retentionExpirationChecker struct {
+	limits StoreLimits
+}
+
+// NewRetentionExpirationChecker returns an ExpirationChecker that expires
+// chunks once they fall outside the tenant's configured retention period.
+func NewRetentionExpirationChecker(limits StoreLimits) ExpirationChecker {
+	return &retentionExpirationChecker{limits: limits}
+}
+
+func (r *retentionExpirationChecker) IntervalHasExpiredChunks(interval model.Interval) bool {
+	// Retention is evaluated relative to "now", so any interval that has
+	// started could, for some tenant's retention period, already be expired.
+	return interval.Start < model.Now()
+}
+
+func (r *retentionExpirationChecker) Expired(chunkRef ChunkEntry, now model.Time) (bool, []model.Interval) {
+	retention := r.limits.RetentionPeriod(chunkRef.UserID)
+	if rules := r.limits.StreamRetention(chunkRef.UserID); len(rules) > 0 {
+		if override, ok := matchStreamRetention(rules, chunkRef.Labels); ok {
+			retention = override
+		}
+	}
+	if retention <= 0 {
+		return false, nil
+	}
+
+	cutoff := now.Add(-retention)
+	if chunkRef.Through < cutoff {
+		return true, nil
+	}
+	if chunkRef.From < cutoff {
+		return true, []model.Interval{{Start: cutoff, End: chunkRef.Through}}
+	}
+	return false, nil
+}
+
+func matchStreamRetention(rules []StreamRetentionRule, lbls labels.Labels) (time.Duration, bool) {
+	for _, rule := range rules {
+		matches := true
+		for _, m := range rule.Matchers {
+			if !m.Matches(lbls.Get(m.Name)) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return rule.Period, true
+		}
+	}
+	return 0, false
+}
+
+// DeleteRequestsStore is the minimal interface a pending tenant
+// delete-request backend must implement for deleteRequestsExpirationChecker
+// to consult it.
+type DeleteRequestsStore interface {
+	// HasPendingDeletesCoveringInterval reports whether any tenant has a
+	// pending delete-request whose interval overlaps interval.
+	HasPendingDeletesCoveringInterval(interval model.Interval) bool
+
+	// DeletedIntervals returns the intervals that userID has requested
+	// deleted for a series matching lbls.
+	DeletedIntervals(userID string, lbls labels.Labels) []model.Interval
+}
+
+// deleteRequestsExpirationChecker is an ExpirationChecker backed by pending
+// tenant delete-requests.
+type deleteRequestsExpirationChecker struct {
+	store DeleteRequestsStore
+}
+
+// NewDeleteRequestsExpirationChecker returns an ExpirationChecker that
+// expires chunks covered by a pending tenant delete-request.
+func NewDeleteRequestsExpirationChecker(store DeleteRequestsStore) ExpirationChecker {
+	return &deleteRequestsExpirationChecker{store: store}
+}
+
+func (d *deleteRequestsExpirationChecker) IntervalHasExpiredChunks(interval model.Interval) bool {
+	return d.store.HasPendingDeletesCoveringInterval(interval)
+}
+
+func (d *deleteRequestsExpirationChecker) Expired(chunkRef ChunkEntry, now model.Time) (bool, []model.Interval) {
+	deleted := d.store.DeletedIntervals(chunkRef.UserID, chunkRef.Labels)
+	if len(deleted) == 0 {
+		return false, nil
+	}
+
+	nonExpired := subtractIntervals(model.Interval{Start: chunkRef.From, End: chunkRef.Through}, deleted)
+	if len(nonExpired) == 0 {
+		return true, nil
+	}
+	if len(nonExpired) == 1 && nonExpired[0].Start == chunkRef.From && nonExpired[0].End == chunkRef.Through {
+		return false, nil
+	}
+	return true, nonExpired
+}
+
+// intersectIntervals returns the portions of a that also fall within some
+// interval of b. A nil b means "nothing survives" (the checker that produced
+// it reported the chunk fully expired), not "no constraint".
+func intersectIntervals(a, b []model.Interval) []model.Interval {
+	var result []model.Interval
+	for _, x := range a {
+		for _, y := range b {
+			start, end := x.Start, x.End
+			if y.Start > start {
+				start = y.Start
+			}
+			if y.End < end {
+				end = y.End
+			}
+			if start <= end {
+				result = append(result, model.Interval{Start: start, End: end})
+			}
+		}
+	}
+	return result
+}
+
+// subtractIntervals returns the portions of whole not covered by any of cut,
+// assuming neither whole nor cut need be sorted/merged beforehand.
+func subtractIntervals(whole model.Interval, cut []model.Interval) []model.Interval {
+	remaining := []model.Interval{whole}
+	for _, c := range cut {
+		var next []model.Interval
+		for _, r := range remaining {
+			if c.End < r.Start || c.Start > r.End {
+				next = append(next, r)
+				continue
+			}
+			if c.Start > r.Start {
+				next = append(next, model.Interval{Start: r.Start, End: c.Start - 1})
+			}
+			if c.End < r.End {
+				next = append(next, model.Interval{Start: c.End + 1, End: r.End})
+			}
+		}
+		remaining = next
+	}
+	return remaining
+}
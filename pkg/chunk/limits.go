@@ -0,0 +1,57 @@
+package chunk
+
+import "time"
+
+// StoreLimits provides the per-tenant configuration the chunk store and its
+// ExpirationCheckers need to know about, so callers can plug in whatever
+// per-tenant overrides mechanism the rest of the service uses without this
+// package depending on it directly.
+type StoreLimits interface {
+	// MaxParallelIndexLookups bounds how many per-matcher index lookups a
+	// single query runs concurrently on behalf of userID. 0 means fall back
+	// to the store-wide StoreConfig.MaxParallelIndexLookups default.
+	// FetchChunks and label value lookups are not bounded by it.
+	MaxParallelIndexLookups(userID string) int
+
+	// RetentionPeriod returns how long userID's chunks are kept before
+	// retentionExpirationChecker considers them expired. 0 means no
+	// retention limit.
+	RetentionPeriod(userID string) time.Duration
+
+	// StreamRetention returns userID's per-stream retention overrides, each
+	// applied in order to any series matching all of its Matchers in place
+	// of RetentionPeriod.
+	StreamRetention(userID string) []StreamRetentionRule
+}
+
+// staticStoreLimits is a StoreLimits that applies the same, non-overridable
+// limits to every tenant. It's useful for single-tenant deployments and
+// tests; a multi-tenant deployment will typically plug in its own
+// per-tenant overrides implementation instead.
+type staticStoreLimits struct {
+	maxParallelIndexLookups int
+	retentionPeriod         time.Duration
+	streamRetention         []StreamRetentionRule
+}
+
+// NewStaticStoreLimits returns a StoreLimits that applies maxParallelIndexLookups,
+// retentionPeriod and streamRetention to every tenant.
+func NewStaticStoreLimits(maxParallelIndexLookups int, retentionPeriod time.Duration, streamRetention []StreamRetentionRule) StoreLimits {
+	return &staticStoreLimits{
+		maxParallelIndexLookups: maxParallelIndexLookups,
+		retentionPeriod:         retentionPeriod,
+		streamRetention:         streamRetention,
+	}
+}
+
+func (s *staticStoreLimits) MaxParallelIndexLookups(_ string) int {
+	return s.maxParallelIndexLookups
+}
+
+func (s *staticStoreLimits) RetentionPeriod(_ string) time.Duration {
+	return s.retentionPeriod
+}
+
+func (s *staticStoreLimits) StreamRetention(_ string) []StreamRetentionRule {
+	return s.streamRetention
+}
@@ -0,0 +1,74 @@
+package chunk
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// tablePeriod is the amount of time covered by a single periodic index
+// table, matching the granularity the table managers use when naming
+// chunk index tables (eg "chunks_18250").
+const tablePeriod = 24 * time.Hour
+
+// ChunkEntry is a single chunk as seen by a table scan: enough information
+// to decide whether it is still needed, and to issue the index/storage
+// deletes if it is not.
+type ChunkEntry struct {
+	TableName string
+	UserID    string
+	Labels    labels.Labels
+
+	ChunkID string
+	From    model.Time
+	Through model.Time
+}
+
+// ExpirationChecker decides whether a chunk, or a whole table, is safe to
+// remove. Implementations are consulted by the Compactor before it bothers
+// scanning a table or issuing a delete, so that tables and chunks which
+// aren't affected by retention or pending delete-requests can be skipped
+// cheaply.
+type ExpirationChecker interface {
+	// IntervalHasExpiredChunks reports whether a table covering the given
+	// interval could contain any expired chunks at all. Returning false
+	// lets the Compactor skip scanning the table entirely.
+	IntervalHasExpiredChunks(interval model.Interval) bool
+
+	// Expired reports whether chunkRef is expired as of now. When it
+	// returns true but the chunk is only partially expired, the returned
+	// intervals are the sub-intervals of the chunk that are NOT expired
+	// and should be kept (re-written via reboundChunk); a nil slice means
+	// the chunk is expired in full.
+	Expired(chunkRef ChunkEntry, now model.Time) (bool, []model.Interval)
+}
+
+// DropFromIndex reports whether, when a table is being finalized (ie it
+// will never be written to again), the index rows for entry can be
+// dropped outright rather than rewritten to exclude it. tableEndTime is
+// the time at which the table stops accepting writes.
+func DropFromIndex(entry ChunkEntry, tableEndTime, now model.Time) bool {
+	return tableEndTime <= now
+}
+
+// intervalForTableName parses the day-number suffix of a periodic index
+// table name and returns the UTC day it covers. Tables whose name doesn't
+// end in a day number are treated as covering all of time, so retention
+// scans never skip them by mistake.
+func intervalForTableName(tableName string) model.Interval {
+	i := strings.LastIndex(tableName, "_")
+	if i == -1 {
+		return model.Interval{Start: 0, End: model.Latest}
+	}
+
+	days, err := strconv.ParseInt(tableName[i+1:], 10, 64)
+	if err != nil {
+		return model.Interval{Start: 0, End: model.Latest}
+	}
+
+	start := model.TimeFromUnix(days * int64(tablePeriod/time.Second))
+	return model.Interval{Start: start, End: start.Add(tablePeriod) - 1}
+}
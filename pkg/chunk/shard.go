@@ -0,0 +1,30 @@
+package chunk
+
+// Shard describes one piece of a query sharded across Of workers: a series
+// belongs to this shard iff its fingerprint, modulo Of, equals Index. It is
+// used by GetChunkRefs so a query-frontend can split a query's chunk
+// references across Of shard workers, each of which fetches and evaluates
+// only its own group of chunks.
+type Shard struct {
+	Index uint32
+	Of    uint32
+}
+
+// SelectParams bundles the non-matcher selection criteria for a chunk
+// lookup. Schema implementations that encode fingerprint bits in their hash
+// key (v10+) can use Shard to prune index queries to a single shard at the
+// index layer, rather than fetching everything and discarding the rest.
+type SelectParams struct {
+	Shard *Shard
+}
+
+// groupChunksByShard buckets chunks by their series fingerprint modulo
+// shards, returning exactly `shards` groups (possibly empty) in index order.
+func groupChunksByShard(chunks []Chunk, shards uint32) [][]Chunk {
+	groups := make([][]Chunk, shards)
+	for _, chunk := range chunks {
+		idx := uint32(uint64(chunk.Fingerprint) % uint64(shards))
+		groups[idx] = append(groups[idx], chunk)
+	}
+	return groups
+}
@@ -12,10 +12,12 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/objstore"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/store"
@@ -23,10 +25,13 @@ import (
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/weaveworks/common/logging"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/cortexproject/cortex/pkg/storage/tsdb"
 	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
 	"github.com/cortexproject/cortex/pkg/util/services"
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
 )
@@ -46,6 +51,25 @@ type UserStore struct {
 	// Index cache shared across all tenants.
 	indexCache storecache.IndexCache
 
+	// shardingStrategy decides which blocks this instance is responsible for
+	// syncing, so that a set of queriers can divide up ownership of a
+	// tenant's blocks via a hash ring rather than every instance syncing
+	// every block.
+	shardingStrategy ShardingStrategy
+
+	// labelShardingFilter further restricts synced blocks to those carrying
+	// the configured external labels. Empty (the default) matches every
+	// block.
+	labelShardingFilter *labelShardingFilter
+
+	// queryGate bounds how many Series/LabelNames/LabelValues calls, across
+	// all tenants, can be running at once, so a burst of queries can't
+	// exhaust querier memory by each tenant's BucketStore serving unbounded
+	// concurrent requests. queryGateTimeout bounds how long a call waits to
+	// acquire the gate before giving up with a retryable error.
+	queryGate        gate.Gate
+	queryGateTimeout time.Duration
+
 	// Keeps a bucket store for each tenant.
 	storesMu sync.RWMutex
 	stores   map[string]*store.BucketStore
@@ -53,31 +77,60 @@ type UserStore struct {
 	serv *grpc.Server
 
 	// Metrics.
-	syncTimes prometheus.Histogram
+	syncTimes        prometheus.Histogram
+	syncFailures     *prometheus.CounterVec
+	gateWaitDuration prometheus.Histogram
+	gateInFlight     prometheus.Gauge
 }
 
-// NewUserStore returns a new UserStore
-func NewUserStore(cfg tsdb.Config, bucketClient objstore.Bucket, logLevel logging.Level, logger log.Logger, registerer prometheus.Registerer) (*UserStore, error) {
+// NewUserStore returns a new UserStore. shardingStrategy decides which of a
+// tenant's blocks this instance owns; pass a noShardingStrategy (the default
+// when tsdb.Config.BucketStore.ShardingEnabled is false) to have every
+// instance sync every block, preserving the pre-sharding behaviour.
+// requiredMetaLabels, if non-empty, further restricts synced blocks to those
+// carrying all of the given external labels.
+func NewUserStore(cfg tsdb.Config, bucketClient objstore.Bucket, shardingStrategy ShardingStrategy, requiredMetaLabels map[string]string, logLevel logging.Level, logger log.Logger, registerer prometheus.Registerer) (*UserStore, error) {
 	indexCacheRegistry := prometheus.NewRegistry()
 
+	if shardingStrategy == nil {
+		shardingStrategy = newNoShardingStrategy()
+	}
+
 	u := &UserStore{
-		logger:             logger,
-		cfg:                cfg,
-		bucket:             bucketClient,
-		stores:             map[string]*store.BucketStore{},
-		logLevel:           logLevel,
-		bucketStoreMetrics: newTSDBBucketStoreMetrics(),
-		indexCacheMetrics:  newTSDBIndexCacheMetrics(indexCacheRegistry),
+		logger:              logger,
+		cfg:                 cfg,
+		bucket:              bucketClient,
+		stores:              map[string]*store.BucketStore{},
+		shardingStrategy:    shardingStrategy,
+		labelShardingFilter: newLabelShardingFilter(requiredMetaLabels),
+		queryGate:           gate.NewKeeper(registerer).NewGate(cfg.BucketStore.MaxConcurrent),
+		queryGateTimeout:    cfg.BucketStore.MaxConcurrentQueueTimeout,
+		logLevel:            logLevel,
+		bucketStoreMetrics:  newTSDBBucketStoreMetrics(),
+		indexCacheMetrics:   newTSDBIndexCacheMetrics(indexCacheRegistry),
 		syncTimes: promauto.With(registerer).NewHistogram(prometheus.HistogramOpts{
 			Name:    "cortex_querier_blocks_sync_seconds",
 			Help:    "The total time it takes to perform a sync stores",
 			Buckets: []float64{0.1, 1, 10, 30, 60, 120, 300, 600, 900},
 		}),
+		syncFailures: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_querier_blocks_sync_failures_total",
+			Help: "Total number of tenants for which syncing TSDB blocks failed.",
+		}, []string{"user"}),
+		gateWaitDuration: promauto.With(registerer).NewHistogram(prometheus.HistogramOpts{
+			Name:    "cortex_bucket_stores_gate_duration_seconds",
+			Help:    "Time spent by queries waiting at the query gate, shared across all tenants, before being allowed to call the per-tenant BucketStore.",
+			Buckets: []float64{0.001, 0.01, 0.1, 0.3, 1, 3, 10, 30},
+		}),
+		gateInFlight: promauto.With(registerer).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_bucket_stores_gate_queries_in_flight",
+			Help: "Number of Series/LabelNames/LabelValues queries currently executing past the query gate.",
+		}),
 	}
 
 	// Init the index cache.
 	var err error
-	if u.indexCache, err = tsdb.NewIndexCache(cfg.BucketStore, logger, indexCacheRegistry); err != nil {
+	if u.indexCache, err = tsdb.NewIndexCache(cfg.BucketStore.IndexCache, logger, indexCacheRegistry); err != nil {
 		return nil, errors.Wrap(err, "create index cache")
 	}
 
@@ -187,58 +240,55 @@ func (u *UserStore) syncStores(ctx context.Context) error {
 	return nil
 }
 
+// syncUserStores lists every tenant currently in the bucket, lazily creates a
+// BucketStore for each, and calls f on each one concurrently, bounded by
+// cfg.BucketStore.TenantSyncConcurrency. Errors returned by f don't abort the
+// sync of the remaining tenants: they're counted in syncFailures and
+// aggregated into the returned error. A failure to list the bucket itself,
+// a failure to create a tenant's BucketStore, or ctx being cancelled aborts
+// the sync early and that error is returned.
 func (u *UserStore) syncUserStores(ctx context.Context, f func(context.Context, *store.BucketStore) error) error {
 	defer func(start time.Time) {
 		u.syncTimes.Observe(time.Since(start).Seconds())
 	}(time.Now())
 
-	type job struct {
-		userID string
-		store  *store.BucketStore
+	var users []string
+	if err := u.bucket.Iter(ctx, "", func(s string) error {
+		users = append(users, strings.TrimSuffix(s, "/"))
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "list users")
 	}
 
-	wg := &sync.WaitGroup{}
-	jobs := make(chan job)
-
-	// Create a pool of workers which will synchronize blocks. The pool size
-	// is limited in order to avoid to concurrently sync a lot of tenants in
-	// a large cluster.
-	for i := 0; i < u.cfg.BucketStore.TenantSyncConcurrency; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-
-			for job := range jobs {
-				if err := f(ctx, job.store); err != nil {
-					level.Warn(u.logger).Log("msg", "failed to synchronize TSDB blocks for user", "user", job.userID, "err", err)
-				}
-			}
-		}()
-	}
+	var (
+		errsMu sync.Mutex
+		errs   *multierror.Error
+	)
 
-	// Iterate the bucket, lazily create a bucket store for each new user found
-	// and submit a sync job for each user.
-	err := u.bucket.Iter(ctx, "", func(s string) error {
-		user := strings.TrimSuffix(s, "/")
+	err := concurrency.ForEachJob(ctx, len(users), u.cfg.BucketStore.TenantSyncConcurrency, func(ctx context.Context, idx int) error {
+		userID := users[idx]
 
-		bs, err := u.getOrCreateStore(user)
+		bs, err := u.getOrCreateStore(userID)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "get bucket store for user %s", userID)
 		}
 
-		jobs <- job{
-			userID: user,
-			store:  bs,
+		if err := f(ctx, bs); err != nil {
+			u.syncFailures.WithLabelValues(userID).Inc()
+			level.Warn(u.logger).Log("msg", "failed to synchronize TSDB blocks for user", "user", userID, "err", err)
+
+			errsMu.Lock()
+			errs = multierror.Append(errs, errors.Wrapf(err, "user %s", userID))
+			errsMu.Unlock()
 		}
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
 
-	// Wait until all workers completed.
-	close(jobs)
-	wg.Wait()
-
-	return err
+	return errs.ErrorOrNil()
 }
 
 // Info makes an info request to the underlying user store
@@ -269,6 +319,11 @@ func (u *UserStore) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesS
 	log, ctx := spanlogger.New(srv.Context(), "UserStore.Series")
 	defer log.Span.Finish()
 
+	if err := u.waitForQueryGate(ctx); err != nil {
+		return err
+	}
+	defer u.releaseQueryGate()
+
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return fmt.Errorf("no metadata")
@@ -292,6 +347,11 @@ func (u *UserStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReque
 	log, ctx := spanlogger.New(ctx, "UserStore.LabelNames")
 	defer log.Span.Finish()
 
+	if err := u.waitForQueryGate(ctx); err != nil {
+		return nil, err
+	}
+	defer u.releaseQueryGate()
+
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return nil, fmt.Errorf("no metadata")
@@ -315,6 +375,11 @@ func (u *UserStore) LabelValues(ctx context.Context, req *storepb.LabelValuesReq
 	log, ctx := spanlogger.New(ctx, "UserStore.LabelValues")
 	defer log.Span.Finish()
 
+	if err := u.waitForQueryGate(ctx); err != nil {
+		return nil, err
+	}
+	defer u.releaseQueryGate()
+
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return nil, fmt.Errorf("no metadata")
@@ -333,6 +398,41 @@ func (u *UserStore) LabelValues(ctx context.Context, req *storepb.LabelValuesReq
 	return store.LabelValues(ctx, req)
 }
 
+// waitForQueryGate blocks until the shared query gate admits this call, up
+// to queryGateTimeout (if set). It returns a gRPC ResourceExhausted error -
+// distinct from the errors a BucketStore itself can return, so a
+// blocks_store_queryable fanning out to multiple replicas can tell "this
+// replica is overloaded, try another" apart from "the query itself failed" -
+// if the wait times out.
+func (u *UserStore) waitForQueryGate(ctx context.Context) error {
+	start := time.Now()
+	defer func() {
+		u.gateWaitDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	waitCtx := ctx
+	if u.queryGateTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, u.queryGateTimeout)
+		defer cancel()
+	}
+
+	if err := u.queryGate.Start(waitCtx); err != nil {
+		if waitCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return status.Errorf(codes.ResourceExhausted, "too many outstanding requests to the blocks store, timed out waiting %s to acquire the query gate", u.queryGateTimeout)
+		}
+		return err
+	}
+
+	u.gateInFlight.Inc()
+	return nil
+}
+
+func (u *UserStore) releaseQueryGate() {
+	u.gateInFlight.Dec()
+	u.queryGate.Done()
+}
+
 func (u *UserStore) getStore(userID string) *store.BucketStore {
 	u.storesMu.RLock()
 	store := u.stores[userID]
@@ -341,6 +441,25 @@ func (u *UserStore) getStore(userID string) *store.BucketStore {
 	return store
 }
 
+// closeEvictedTenant stops and forgets the BucketStore for userID, called by
+// a BlocksCleaner once a tenant's bucket has been empty for longer than its
+// configured idle window. It's a no-op if the tenant has no store here.
+func (u *UserStore) closeEvictedTenant(userID string) error {
+	u.storesMu.Lock()
+	bs, ok := u.stores[userID]
+	if ok {
+		delete(u.stores, userID)
+	}
+	u.storesMu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	u.bucketStoreMetrics.removeUserRegistry(userID)
+	return bs.Close()
+}
+
 func (u *UserStore) getOrCreateStore(userID string) (*store.BucketStore, error) {
 	// Check if the store already exists.
 	bs := u.getStore(userID)
@@ -364,18 +483,51 @@ func (u *UserStore) getOrCreateStore(userID string) (*store.BucketStore, error)
 	userBkt := tsdb.NewUserBucketClient(userID, u.bucket)
 
 	reg := prometheus.NewRegistry()
-	fetcher, err := block.NewMetaFetcher(
+
+	// A single BaseFetcher does the actual bucket listing/caching of
+	// meta.json files for this tenant; the MetaFetcher built on top of it
+	// runs the configurable pipeline of MetaFilters below and reports, via
+	// reg, why any given block was or wasn't loaded.
+	baseFetcher, err := block.NewBaseFetcher(
 		userLogger,
 		u.cfg.BucketStore.MetaSyncConcurrency,
 		userBkt,
 		filepath.Join(u.cfg.BucketStore.SyncDir, userID), // The fetcher stores cached metas in the "meta-syncer/" sub directory
 		reg,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher := baseFetcher.NewMetaFetcher(
+		reg,
 		// List of filters to apply (order matters).
 		block.NewConsistencyDelayMetaFilter(userLogger, u.cfg.BucketStore.ConsistencyDelay, reg).Filter,
 		// Filters out duplicate blocks that can be formed from two or more overlapping
 		// blocks that fully submatches the source blocks of the older blocks.
 		block.NewDeduplicateFilter().Filter,
+		// Excludes blocks marked for deletion once they're past the grace
+		// period, so a BlocksCleaner's in-flight deletion doesn't race with
+		// a querier still serving the block.
+		block.NewIgnoreDeletionMarkFilter(userLogger, userBkt, u.cfg.BucketStore.IgnoreDeletionMarksDelay, u.cfg.BucketStore.MetaSyncConcurrency).Filter,
+		// Restricts this instance to blocks overlapping the configured time
+		// range, if any.
+		block.NewTimePartitionMetaFilter(u.cfg.BucketStore.MinTime, u.cfg.BucketStore.MaxTime).Filter,
+		// Restricts this instance to blocks carrying the required external
+		// labels, used by the blocks sharding feature.
+		u.labelShardingFilter.Filter,
+		// Filters out blocks this instance doesn't own, so that with sharding
+		// enabled each querier only downloads the index-header and meta.json
+		// of the blocks its ring replica set is responsible for.
+		u.shardingStrategy.FilterBlocks,
 	)
+
+	// Wrap the tenant's bucket with a read-through cache for the BucketStore
+	// itself, so repeated index-header reads (eg. after a querier restart)
+	// don't always have to go back to object storage. The MetaFetcher above
+	// keeps using the uncached userBkt, since meta.json listing is already
+	// cached by the BaseFetcher.
+	cachingBkt, err := tsdb.NewCachingBucket(userBkt, u.cfg.BucketStore.ChunksCache, userLogger, reg)
 	if err != nil {
 		return nil, err
 	}
@@ -383,7 +535,7 @@ func (u *UserStore) getOrCreateStore(userID string) (*store.BucketStore, error)
 	bs, err = store.NewBucketStore(
 		userLogger,
 		reg,
-		userBkt,
+		cachingBkt,
 		fetcher,
 		filepath.Join(u.cfg.BucketStore.SyncDir, userID),
 		u.indexCache,
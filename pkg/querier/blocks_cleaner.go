@@ -0,0 +1,239 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/objstore"
+
+	"github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/cortexproject/cortex/pkg/util/spanlogger"
+)
+
+// BlocksCleanerConfig configures the BlocksCleaner.
+type BlocksCleanerConfig struct {
+	CleanupInterval    time.Duration `yaml:"cleanup_interval"`
+	CleanupConcurrency int           `yaml:"cleanup_concurrency"`
+	DeletionDelay      time.Duration `yaml:"deletion_delay"`
+	TenantIdleTimeout  time.Duration `yaml:"tenant_idle_timeout"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *BlocksCleanerConfig) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.CleanupInterval, "blocks-storage.bucket-store.cleanup-interval", 15*time.Minute, "How frequently the blocks cleaner scans the bucket for expired blocks and idle tenants.")
+	f.IntVar(&cfg.CleanupConcurrency, "blocks-storage.bucket-store.cleanup-concurrency", 20, "Max number of tenants to process concurrently while cleaning up blocks.")
+	f.DurationVar(&cfg.DeletionDelay, "blocks-storage.bucket-store.deletion-delay", 12*time.Hour, "How long a block marked for deletion is left in the bucket before it's actually deleted, to give queriers time to notice the mark and stop using it.")
+	f.DurationVar(&cfg.TenantIdleTimeout, "blocks-storage.bucket-store.tenant-idle-timeout", time.Hour, "How long a tenant's bucket can have no blocks before its BucketStore is closed and removed from UserStore.")
+}
+
+// BlocksCleanerLimits provides the per-tenant configuration a BlocksCleaner
+// needs that isn't tied to the bucket itself.
+type BlocksCleanerLimits interface {
+	// CompactorBlocksRetentionPeriod returns how long blocks are kept for
+	// userID before being marked for deletion. 0 means no retention limit.
+	CompactorBlocksRetentionPeriod(userID string) time.Duration
+}
+
+// BlocksCleaner is a background service that, for every tenant found in the
+// bucket, marks blocks past their retention period for deletion, deletes
+// blocks whose deletion mark is older than DeletionDelay, and evicts tenants
+// whose bucket has been empty for longer than TenantIdleTimeout from a
+// UserStore. It's runnable standalone (userStore nil skips the eviction
+// step) or embedded in a querier alongside its UserStore.
+type BlocksCleaner struct {
+	services.Service
+
+	cfg       BlocksCleanerConfig
+	bucket    objstore.Bucket
+	limits    BlocksCleanerLimits
+	userStore *UserStore
+	logger    log.Logger
+
+	// emptySince tracks, per tenant, the first time its bucket was observed
+	// to have zero blocks, so TenantIdleTimeout is measured across runs
+	// rather than tripping on the first empty scan.
+	emptySinceMu sync.Mutex
+	emptySince   map[string]time.Time
+
+	blocksCount             *prometheus.GaugeVec
+	blocksMarkedForDeletion *prometheus.GaugeVec
+	lastSuccessfulRun       *prometheus.GaugeVec
+	tenantsEvicted          prometheus.Counter
+}
+
+// NewBlocksCleaner makes a new BlocksCleaner. userStore may be nil when
+// running the cleaner as a standalone component with no local UserStore to
+// evict tenants from.
+func NewBlocksCleaner(cfg BlocksCleanerConfig, bucketClient objstore.Bucket, limits BlocksCleanerLimits, userStore *UserStore, logger log.Logger, registerer prometheus.Registerer) *BlocksCleaner {
+	c := &BlocksCleaner{
+		cfg:        cfg,
+		bucket:     bucketClient,
+		limits:     limits,
+		userStore:  userStore,
+		logger:     logger,
+		emptySince: map[string]time.Time{},
+
+		blocksCount: promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_bucket_blocks_count",
+			Help: "Total number of blocks in the bucket.",
+		}, []string{"user"}),
+		blocksMarkedForDeletion: promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_bucket_blocks_marked_for_deletion_count",
+			Help: "Total number of blocks marked for deletion in the bucket.",
+		}, []string{"user"}),
+		lastSuccessfulRun: promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_bucket_blocks_cleanup_last_successful_run_timestamp_seconds",
+			Help: "Unix timestamp of the last successful blocks cleanup run for the tenant.",
+		}, []string{"user"}),
+		tenantsEvicted: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_bucket_blocks_cleanup_tenants_evicted_total",
+			Help: "Total number of tenants evicted from UserStore because their bucket was idle for longer than tenant_idle_timeout.",
+		}),
+	}
+
+	c.Service = services.NewTimerService(cfg.CleanupInterval, c.cleanupUsers, c.cleanupUsers, nil)
+	return c
+}
+
+// cleanupUsers iterates every tenant currently in the bucket and cleans up
+// their blocks concurrently, bounded by cfg.CleanupConcurrency.
+func (c *BlocksCleaner) cleanupUsers(ctx context.Context) error {
+	log, ctx := spanlogger.New(ctx, "BlocksCleaner.cleanupUsers")
+	defer log.Span.Finish()
+
+	var users []string
+	err := c.bucket.Iter(ctx, "", func(s string) error {
+		users = append(users, strings.TrimSuffix(s, "/"))
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "list users")
+	}
+
+	return concurrency.ForEachJob(ctx, len(users), c.cfg.CleanupConcurrency, func(ctx context.Context, idx int) error {
+		userID := users[idx]
+		if err := c.cleanUser(ctx, userID); err != nil {
+			level.Warn(util.WithUserID(userID, c.logger)).Log("msg", "failed to cleanup blocks for tenant", "err", err)
+		}
+		return nil
+	})
+}
+
+// cleanUser applies retention (by writing deletion marks), deletes blocks
+// whose deletion mark is older than DeletionDelay, and evicts the tenant
+// from UserStore once its bucket has been empty for TenantIdleTimeout.
+func (c *BlocksCleaner) cleanUser(ctx context.Context, userID string) error {
+	userLogger := util.WithUserID(userID, c.logger)
+	userBkt := tsdb.NewUserBucketClient(userID, c.bucket)
+	retention := c.limits.CompactorBlocksRetentionPeriod(userID)
+
+	var (
+		blocksCount     int
+		markedForDelete int
+	)
+
+	err := userBkt.Iter(ctx, "", func(name string) error {
+		id, ok := block.IsBlockDir(name)
+		if !ok {
+			return nil
+		}
+		blocksCount++
+
+		markerExists, err := userBkt.Exists(ctx, block.DeletionMarkFilepath(id))
+		if err != nil {
+			return errors.Wrapf(err, "check deletion mark for block %s", id.String())
+		}
+
+		switch {
+		case markerExists:
+			markedForDelete++
+
+			mark, err := block.ReadDeletionMark(ctx, userBkt, userLogger, id)
+			if err != nil {
+				return errors.Wrapf(err, "read deletion mark for block %s", id.String())
+			}
+			if time.Since(time.Unix(mark.DeletionTime, 0)) < c.cfg.DeletionDelay {
+				return nil
+			}
+
+			if err := block.Delete(ctx, userLogger, userBkt, id); err != nil {
+				return errors.Wrapf(err, "delete block %s", id.String())
+			}
+			blocksCount--
+			markedForDelete--
+
+		case retention > 0:
+			meta, err := block.DownloadMeta(ctx, userLogger, userBkt, id)
+			if err != nil {
+				return errors.Wrapf(err, "download meta for block %s", id.String())
+			}
+
+			if model.Time(meta.MaxTime).Time().Before(time.Now().Add(-retention)) {
+				if err := block.MarkForDeletion(ctx, userLogger, userBkt, id, "block exceeds configured retention period", nil); err != nil {
+					return errors.Wrapf(err, "mark block %s for deletion", id.String())
+				}
+				markedForDelete++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "iterate tenant bucket")
+	}
+
+	c.blocksCount.WithLabelValues(userID).Set(float64(blocksCount))
+	c.blocksMarkedForDeletion.WithLabelValues(userID).Set(float64(markedForDelete))
+	c.lastSuccessfulRun.WithLabelValues(userID).SetToCurrentTime()
+
+	c.maybeEvictIdleTenant(userID, blocksCount)
+	return nil
+}
+
+// maybeEvictIdleTenant removes userID's entry from UserStore.stores and
+// closes its BucketStore once its bucket has had zero blocks for longer
+// than TenantIdleTimeout.
+func (c *BlocksCleaner) maybeEvictIdleTenant(userID string, blocksCount int) {
+	c.emptySinceMu.Lock()
+	emptySince, wasEmpty := c.emptySince[userID]
+	if blocksCount > 0 {
+		delete(c.emptySince, userID)
+		c.emptySinceMu.Unlock()
+		return
+	}
+	if !wasEmpty {
+		emptySince = time.Now()
+		c.emptySince[userID] = emptySince
+	}
+	idleFor := time.Since(emptySince)
+	c.emptySinceMu.Unlock()
+
+	if c.userStore == nil || idleFor < c.cfg.TenantIdleTimeout {
+		return
+	}
+
+	if err := c.userStore.closeEvictedTenant(userID); err != nil {
+		level.Warn(util.WithUserID(userID, c.logger)).Log("msg", "failed to close BucketStore for evicted tenant", "err", err)
+		return
+	}
+
+	c.emptySinceMu.Lock()
+	delete(c.emptySince, userID)
+	c.emptySinceMu.Unlock()
+
+	c.tenantsEvicted.Inc()
+	level.Info(util.WithUserID(userID, c.logger)).Log("msg", "evicted idle tenant from UserStore", "idle_for", idleFor)
+}
@@ -0,0 +1,46 @@
+package querier
+
+import (
+	"context"
+
+	"github.com/oklog/ulid"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extprom"
+)
+
+// labelShardingFilter restricts the blocks a tenant's MetaFetcher loads to
+// those whose external labels carry every one of requiredLabels. It's used
+// by the blocks sharding feature to split a tenant's blocks across
+// replicas by an external label (eg. a shard label the compactor stamped
+// onto the block) in addition to the hash-ring based ShardingStrategy.
+type labelShardingFilter struct {
+	requiredLabels map[string]string
+}
+
+// newLabelShardingFilter returns a filter that excludes any block not
+// carrying every one of requiredLabels. An empty/nil requiredLabels matches
+// every block.
+func newLabelShardingFilter(requiredLabels map[string]string) *labelShardingFilter {
+	return &labelShardingFilter{requiredLabels: requiredLabels}
+}
+
+func (f *labelShardingFilter) Filter(_ context.Context, metas map[ulid.ULID]*metadata.Meta, synced *extprom.TxGaugeVec) error {
+	if len(f.requiredLabels) == 0 {
+		return nil
+	}
+
+nextBlock:
+	for id, meta := range metas {
+		for name, value := range f.requiredLabels {
+			if meta.Thanos.Labels[name] != value {
+				delete(metas, id)
+				if synced != nil {
+					synced.WithLabelValues(shardExcludedMeta).Inc()
+				}
+				continue nextBlock
+			}
+		}
+	}
+
+	return nil
+}
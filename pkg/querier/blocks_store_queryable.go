@@ -0,0 +1,144 @@
+package querier
+
+import (
+	"context"
+	"io"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// maxFetchRetries bounds how many attempts in total (the first one included)
+// BlocksStoreQueryable makes for a block, each against a different replica
+// after an RPC to the one GetClientsFor picked fails, roughly matching how
+// many replicas a block's replication set can realistically hold.
+const maxFetchRetries = 3
+
+// BlocksStoreQueryable fans Series/LabelNames/LabelValues requests for a set
+// of blocks out to whichever store-gateway instances own them (per stores),
+// merging their responses into the single view a PromQL query expects. A
+// store-gateway that fails or times out is retried against another replica
+// from its replication set rather than dropping the block's data.
+type BlocksStoreQueryable struct {
+	stores BlocksStoreSet
+}
+
+// NewBlocksStoreQueryable returns a BlocksStoreQueryable backed by stores.
+func NewBlocksStoreQueryable(stores BlocksStoreSet) *BlocksStoreQueryable {
+	return &BlocksStoreQueryable{stores: stores}
+}
+
+// SeriesForBlocks fetches req from whichever store-gateways own blockIDs,
+// retrying any block whose store-gateway fails against another replica, and
+// returns the merged result.
+func (q *BlocksStoreQueryable) SeriesForBlocks(ctx context.Context, userID string, blockIDs []ulid.ULID, req *storepb.SeriesRequest) ([]*storepb.Series, error) {
+	var merged [][]*storepb.Series
+
+	err := q.forEachBlock(ctx, userID, blockIDs, func(ctx context.Context, client BlocksStoreClient, ids []ulid.ULID) error {
+		stream, err := client.Series(ctx, req)
+		if err != nil {
+			return errors.Wrapf(err, "series call to store-gateway %s", client.RemoteAddress())
+		}
+
+		var series []*storepb.Series
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Wrapf(err, "receive series from store-gateway %s", client.RemoteAddress())
+			}
+			if s := resp.GetSeries(); s != nil {
+				series = append(series, s)
+			}
+		}
+
+		merged = append(merged, series)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeSeriesResponses(merged), nil
+}
+
+// LabelNamesForBlocks fetches req from whichever store-gateways own
+// blockIDs, retrying any block whose store-gateway fails against another
+// replica, and returns the merged, deduplicated, sorted result.
+func (q *BlocksStoreQueryable) LabelNamesForBlocks(ctx context.Context, userID string, blockIDs []ulid.ULID, req *storepb.LabelNamesRequest) ([]string, error) {
+	var responses []*storepb.LabelNamesResponse
+
+	err := q.forEachBlock(ctx, userID, blockIDs, func(ctx context.Context, client BlocksStoreClient, ids []ulid.ULID) error {
+		resp, err := client.LabelNames(ctx, req)
+		if err != nil {
+			return errors.Wrapf(err, "label names call to store-gateway %s", client.RemoteAddress())
+		}
+		responses = append(responses, resp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeLabelNamesResponses(responses), nil
+}
+
+// LabelValuesForBlocks fetches req from whichever store-gateways own
+// blockIDs, retrying any block whose store-gateway fails against another
+// replica, and returns the merged, deduplicated, sorted result.
+func (q *BlocksStoreQueryable) LabelValuesForBlocks(ctx context.Context, userID string, blockIDs []ulid.ULID, req *storepb.LabelValuesRequest) ([]string, error) {
+	var responses []*storepb.LabelValuesResponse
+
+	err := q.forEachBlock(ctx, userID, blockIDs, func(ctx context.Context, client BlocksStoreClient, ids []ulid.ULID) error {
+		resp, err := client.LabelValues(ctx, req)
+		if err != nil {
+			return errors.Wrapf(err, "label values call to store-gateway %s", client.RemoteAddress())
+		}
+		responses = append(responses, resp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeLabelValuesResponses(responses), nil
+}
+
+// forEachBlock resolves a BlocksStoreClient for each of blockIDs and calls
+// call once per client with the subset of blockIDs it owns, retrying any
+// block whose call returns an error against another replica (tracked via
+// the exclude set passed back into GetClientsFor), for at most
+// maxFetchRetries attempts in total before giving up on it.
+func (q *BlocksStoreQueryable) forEachBlock(ctx context.Context, userID string, blockIDs []ulid.ULID, call func(ctx context.Context, client BlocksStoreClient, ids []ulid.ULID) error) error {
+	remaining := blockIDs
+	exclude := map[ulid.ULID][]string{}
+
+	for attempt := 0; attempt < maxFetchRetries && len(remaining) > 0; attempt++ {
+		clients, err := q.stores.GetClientsFor(userID, remaining, exclude)
+		if err != nil {
+			return errors.Wrap(err, "find store-gateways for blocks")
+		}
+
+		var retry []ulid.ULID
+		for client, ids := range clients {
+			if err := call(ctx, client, ids); err != nil {
+				for _, id := range ids {
+					exclude[id] = append(exclude[id], client.RemoteAddress())
+				}
+				retry = append(retry, ids...)
+				continue
+			}
+		}
+
+		remaining = retry
+	}
+
+	if len(remaining) > 0 {
+		return errors.Errorf("failed to fetch %d block(s) after %d attempts against different replicas", len(remaining), maxFetchRetries)
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package querier
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+)
+
+// BlocksStoreClient is a storepb.StoreClient bound to a specific
+// store-gateway instance, so callers can report which replica a given
+// Series/LabelNames/LabelValues call actually hit.
+type BlocksStoreClient interface {
+	storepb.StoreClient
+
+	// RemoteAddress returns the address of the store-gateway instance this
+	// client talks to.
+	RemoteAddress() string
+}
+
+// BlocksStoreSet resolves the BlocksStoreClient(s) to use to fetch a given
+// set of blocks for a tenant, so that querying a large number of blocks can
+// be fanned out to whichever store-gateway instances currently own them.
+type BlocksStoreSet interface {
+	// GetClientsFor returns a map from client to the subset of blockIDs that
+	// client owns. Every block in blockIDs is assigned to exactly one
+	// client, skipping any address excluded for that block (so a caller
+	// whose RPC to that address failed can retry the block against another
+	// replica in its replication set). An error is returned for any block
+	// whose replication set is fully excluded.
+	GetClientsFor(userID string, blockIDs []ulid.ULID, exclude map[ulid.ULID][]string) (map[BlocksStoreClient][]ulid.ULID, error)
+}
+
+// blocksStoreReplicationSet is a BlocksStoreSet that resolves block
+// ownership through a consistent-hash ring, mirroring how the
+// ingester/distributor rings resolve series ownership.
+type blocksStoreReplicationSet struct {
+	ring *ring.Ring
+	pool *blocksStoreClientPool
+}
+
+// NewBlocksStoreReplicationSet returns a BlocksStoreSet backed by r, dialing
+// store-gateway clients lazily and caching them per address.
+func NewBlocksStoreReplicationSet(r *ring.Ring, dialOpts ...grpc.DialOption) BlocksStoreSet {
+	return &blocksStoreReplicationSet{
+		ring: r,
+		pool: newBlocksStoreClientPool(dialOpts...),
+	}
+}
+
+func (s *blocksStoreReplicationSet) GetClientsFor(_ string, blockIDs []ulid.ULID, exclude map[ulid.ULID][]string) (map[BlocksStoreClient][]ulid.ULID, error) {
+	blockIDsByAddr := map[string][]ulid.ULID{}
+
+	for _, blockID := range blockIDs {
+		rs, err := s.ring.Get(hashBlockID(blockID), ring.BlocksSync, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "find replicas for block %s", blockID.String())
+		}
+		if len(rs.Ingesters) == 0 {
+			return nil, errors.Errorf("no replicas found in the ring for block %s", blockID.String())
+		}
+
+		// Any replica in the set can serve the block. Picking the first one
+		// not already excluded (because a previous attempt against it
+		// failed) keeps the fan-out to one RPC per address rather than one
+		// per block, while still letting a caller fail over to the next
+		// replica instead of losing the block entirely.
+		addr := firstNotExcluded(rs.Ingesters, exclude[blockID])
+		if addr == "" {
+			return nil, errors.Errorf("no more replicas to try for block %s: all %d were excluded", blockID.String(), len(rs.Ingesters))
+		}
+		blockIDsByAddr[addr] = append(blockIDsByAddr[addr], blockID)
+	}
+
+	clients := make(map[BlocksStoreClient][]ulid.ULID, len(blockIDsByAddr))
+	for addr, ids := range blockIDsByAddr {
+		client, err := s.pool.GetClientFor(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get client for store-gateway %s", addr)
+		}
+		clients[client] = ids
+	}
+
+	return clients, nil
+}
+
+// firstNotExcluded returns the address of the first of replicas whose
+// address isn't in excluded, or "" if every replica is excluded.
+func firstNotExcluded(replicas []ring.IngesterDesc, excluded []string) string {
+	for _, replica := range replicas {
+		isExcluded := false
+		for _, addr := range excluded {
+			if replica.Addr == addr {
+				isExcluded = true
+				break
+			}
+		}
+		if !isExcluded {
+			return replica.Addr
+		}
+	}
+	return ""
+}
+
+// blocksStoreClientPool lazily dials and caches a BlocksStoreClient per
+// store-gateway address, so repeated queries don't pay a dial cost per RPC.
+type blocksStoreClientPool struct {
+	dialOpts []grpc.DialOption
+
+	mtx     sync.Mutex
+	clients map[string]BlocksStoreClient
+}
+
+func newBlocksStoreClientPool(dialOpts ...grpc.DialOption) *blocksStoreClientPool {
+	return &blocksStoreClientPool{
+		dialOpts: dialOpts,
+		clients:  map[string]BlocksStoreClient{},
+	}
+}
+
+func (p *blocksStoreClientPool) GetClientFor(addr string) (BlocksStoreClient, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if c, ok := p.clients[addr]; ok {
+		return c, nil
+	}
+
+	conn, err := grpc.Dial(addr, p.dialOpts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial store-gateway %s", addr)
+	}
+
+	c := &blocksStoreClient{
+		StoreClient: storepb.NewStoreClient(conn),
+		remoteAddr:  addr,
+	}
+	p.clients[addr] = c
+
+	return c, nil
+}
+
+type blocksStoreClient struct {
+	storepb.StoreClient
+
+	remoteAddr string
+}
+
+func (c *blocksStoreClient) RemoteAddress() string {
+	return c.remoteAddr
+}
+
+// mergeLabelNamesResponses merges and deduplicates the label names returned
+// by multiple store-gateway replicas into a single sorted slice.
+func mergeLabelNamesResponses(responses []*storepb.LabelNamesResponse) []string {
+	unique := map[string]struct{}{}
+	for _, resp := range responses {
+		for _, name := range resp.Names {
+			unique[name] = struct{}{}
+		}
+	}
+
+	names := make([]string, 0, len(unique))
+	for name := range unique {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// mergeLabelValuesResponses merges and deduplicates the label values
+// returned by multiple store-gateway replicas into a single sorted slice.
+func mergeLabelValuesResponses(responses []*storepb.LabelValuesResponse) []string {
+	unique := map[string]struct{}{}
+	for _, resp := range responses {
+		for _, value := range resp.Values {
+			unique[value] = struct{}{}
+		}
+	}
+
+	values := make([]string, 0, len(unique))
+	for value := range unique {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	return values
+}
+
+// mergeSeriesResponses merges the series returned by multiple store-gateway
+// replicas into a single, label-sorted slice. It doesn't deduplicate or
+// merge chunks between entries with equal labels. The request sent to each
+// store-gateway is not restricted to the blocks GetClientsFor assigned to
+// it, so a store-gateway holding replicas of other blocks can return the
+// same series, with the same chunks, as another one; all such entries are
+// kept.
+func mergeSeriesResponses(series [][]*storepb.Series) []*storepb.Series {
+	total := 0
+	for _, s := range series {
+		total += len(s)
+	}
+
+	merged := make([]*storepb.Series, 0, total)
+	for _, s := range series {
+		merged = append(merged, s...)
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return compareLabels(merged[i].Labels, merged[j].Labels) < 0
+	})
+
+	return merged
+}
+
+// compareLabels compares two sorted storepb.Label slices the same way
+// labels.Compare compares labels.Labels.
+func compareLabels(a, b []storepb.Label) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].Name != b[i].Name {
+			return strings.Compare(a[i].Name, b[i].Name)
+		}
+		if a[i].Value != b[i].Value {
+			return strings.Compare(a[i].Value, b[i].Value)
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,96 @@
+package querier
+
+import (
+	"context"
+	"hash/fnv"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extprom"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+)
+
+// shardExcludedMeta is the synced-blocks reason reported for blocks that
+// were filtered out because this instance doesn't own them, following the
+// same convention as thanos' own meta filters (eg. "time-excluded").
+const shardExcludedMeta = "shard-excluded"
+
+// ShardingStrategy decides which of a tenant's blocks this querier instance
+// is responsible for syncing. Its FilterBlocks method has the signature of a
+// MetaFetcher filter function, so it can be chained into a MetaFetcher's
+// filter pipeline alongside the consistency-delay and dedup filters already
+// in use.
+type ShardingStrategy interface {
+	FilterBlocks(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, synced *extprom.TxGaugeVec) error
+}
+
+// noShardingStrategy is used when blocks sharding is disabled: every
+// instance owns every block, matching the pre-sharding behaviour.
+type noShardingStrategy struct{}
+
+func newNoShardingStrategy() ShardingStrategy {
+	return &noShardingStrategy{}
+}
+
+func (noShardingStrategy) FilterBlocks(_ context.Context, _ map[ulid.ULID]*metadata.Meta, _ *extprom.TxGaugeVec) error {
+	return nil
+}
+
+// ringShardingStrategy shards blocks across queriers using a consistent-hash
+// ring: an instance owns a block iff it's in the replication set the ring
+// returns for a hash of the block's ULID, the same way the ingester ring
+// assigns series to ingesters by token.
+type ringShardingStrategy struct {
+	r            ring.ReadRing
+	instanceAddr string
+}
+
+func newRingShardingStrategy(r ring.ReadRing, instanceAddr string) ShardingStrategy {
+	return &ringShardingStrategy{
+		r:            r,
+		instanceAddr: instanceAddr,
+	}
+}
+
+func (s *ringShardingStrategy) FilterBlocks(_ context.Context, metas map[ulid.ULID]*metadata.Meta, synced *extprom.TxGaugeVec) error {
+	for id := range metas {
+		owned, err := s.ownsBlock(id)
+		if err != nil {
+			return errors.Wrapf(err, "check ownership of block %s", id.String())
+		}
+		if !owned {
+			delete(metas, id)
+			if synced != nil {
+				synced.WithLabelValues(shardExcludedMeta).Inc()
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *ringShardingStrategy) ownsBlock(id ulid.ULID) (bool, error) {
+	rs, err := s.r.Get(hashBlockID(id), ring.BlocksSync, nil)
+	if err != nil {
+		return false, err
+	}
+
+	for _, instance := range rs.Ingesters {
+		if instance.Addr == s.instanceAddr {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// hashBlockID hashes a block ULID into the ring's token space, so block
+// ownership can be resolved through the ring the same way a series'
+// fingerprint is.
+func hashBlockID(id ulid.ULID) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id.String()))
+	return h.Sum32()
+}
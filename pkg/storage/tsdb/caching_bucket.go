@@ -0,0 +1,165 @@
+package tsdb
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/cacheutil"
+	"github.com/thanos-io/thanos/pkg/objstore"
+)
+
+// memcachedSetTTL is how long a cached index-header object is kept in
+// memcached. Block files are immutable once written, so this only needs to
+// be long enough to be worth the round trip, not a correctness bound.
+const memcachedSetTTL = 24 * time.Hour
+
+// CachingBucketConfig configures a read-through cache placed in front of a
+// tenant's storage bucket, used to avoid re-fetching the same index-header
+// bytes from object storage on every BucketStore restart or eviction.
+// Sharing the IndexCacheBackend* constants with IndexCacheConfig keeps the
+// two independent caches (one for postings/series, one for raw bucket
+// reads) configured the same way.
+type CachingBucketConfig struct {
+	Backend   string                    `yaml:"backend"`
+	Memcached MemcachedIndexCacheConfig `yaml:"memcached"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *CachingBucketConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Backend, "blocks-storage.bucket-store.chunks-cache.backend", "", fmt.Sprintf("The caching bucket backend to use. Supported values: %s, or empty to disable. A caching bucket avoids re-fetching index-header bytes from object storage once they're cached.", []string{IndexCacheBackendMemcached}))
+	cfg.Memcached.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.chunks-cache.memcached.")
+}
+
+// Validate checks the caching bucket config and returns an error if it's invalid.
+func (cfg *CachingBucketConfig) Validate() error {
+	if cfg.Backend != "" && cfg.Backend != IndexCacheBackendMemcached {
+		return fmt.Errorf("unsupported caching bucket backend: %s, supported values: %s", cfg.Backend, []string{IndexCacheBackendMemcached})
+	}
+	return nil
+}
+
+// NewCachingBucket wraps bucketClient with a read-through cache when cfg
+// configures one, or returns bucketClient unmodified when caching is
+// disabled (the default).
+func NewCachingBucket(bucketClient objstore.Bucket, cfg CachingBucketConfig, logger log.Logger, registerer prometheus.Registerer) (objstore.Bucket, error) {
+	switch cfg.Backend {
+	case "":
+		return bucketClient, nil
+	case IndexCacheBackendMemcached:
+		client, err := cacheutil.NewMemcachedClientWithConfig(logger, "chunks-cache", cacheutil.MemcachedClientConfig{
+			Addresses:              strings.Split(cfg.Memcached.Addresses, ","),
+			Timeout:                cfg.Memcached.Timeout,
+			MaxAsyncConcurrency:    cfg.Memcached.MaxAsyncConcurrency,
+			MaxAsyncBufferSize:     cfg.Memcached.MaxAsyncBufferSize,
+			MaxItemSize:            cacheutil.MemcachedMaxItemSize(cfg.Memcached.MaxItemSize),
+			MaxGetMultiConcurrency: cfg.Memcached.MaxGetMultiConcurrency,
+			MaxGetMultiBatchSize:   cfg.Memcached.MaxGetMultiBatchSize,
+			MaxIdleConnections:     cfg.Memcached.MaxIdleConnections,
+		}, registerer)
+		if err != nil {
+			return nil, errors.Wrap(err, "create memcached client")
+		}
+
+		return newCachingBucket(bucketClient, client, logger, registerer), nil
+	default:
+		return nil, fmt.Errorf("unsupported caching bucket backend: %s", cfg.Backend)
+	}
+}
+
+// cachingBucket wraps an objstore.Bucket, caching both whole-object Get
+// results and byte-range GetRange results (the latter being how BucketStore
+// actually fetches chunk data) in client. Every other operation (Iter,
+// Exists, Attributes, Upload, Delete, ...) passes straight through to
+// bucket, since only repeated reads of immutable block files benefit from
+// caching.
+type cachingBucket struct {
+	objstore.Bucket
+
+	client cacheutil.RemoteCacheClient
+	logger log.Logger
+
+	requests *prometheus.CounterVec
+	hits     *prometheus.CounterVec
+}
+
+func newCachingBucket(bucket objstore.Bucket, client cacheutil.RemoteCacheClient, logger log.Logger, registerer prometheus.Registerer) *cachingBucket {
+	return &cachingBucket{
+		Bucket: bucket,
+		client: client,
+		logger: logger,
+		requests: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_bucket_cache_requests_total",
+			Help: "Total number of object requests made through the caching bucket.",
+		}, []string{"item_type"}),
+		hits: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_bucket_cache_hits_total",
+			Help: "Total number of object requests served from cache.",
+		}, []string{"item_type"}),
+	}
+}
+
+// Get returns a cached copy of name's contents when present, and otherwise
+// fetches it from the underlying bucket and stores the result in the cache
+// for next time. name is used as the cache key directly, since block
+// storage paths are content-addressed (a ULID-prefixed path never changes
+// contents once written).
+func (b *cachingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.getCached(ctx, "get", name, func() (io.ReadCloser, error) {
+		return b.Bucket.Get(ctx, name)
+	})
+}
+
+// GetRange is like Get, but caches the specific byte range requested rather
+// than the whole object. This is the path BucketStore actually reads chunk
+// data through, so it's what makes the chunks-cache backend live up to its
+// name rather than only ever caching whole-object reads like the index file.
+func (b *cachingBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	key := name + "#" + strconv.FormatInt(off, 10) + "#" + strconv.FormatInt(length, 10)
+
+	return b.getCached(ctx, "get_range", key, func() (io.ReadCloser, error) {
+		return b.Bucket.GetRange(ctx, name, off, length)
+	})
+}
+
+// getCached serves key from the cache when present under itemType, and
+// otherwise calls fetch, caching its result (read fully into memory, since
+// that's what a bucket read returns anyway) before returning it.
+func (b *cachingBucket) getCached(ctx context.Context, itemType, key string, fetch func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	b.requests.WithLabelValues(itemType).Inc()
+
+	if data := b.client.GetMulti(ctx, []string{key})[key]; data != nil {
+		b.hits.WithLabelValues(itemType).Inc()
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	reader, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	b.client.SetAsync(key, data, memcachedSetTTL, func(err error) {
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to store object in caching bucket", "key", key, "err", err)
+		}
+	})
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
@@ -0,0 +1,50 @@
+package concurrency
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ForEachJob runs jobFunc for each index in [0, jobs), using up to concurrency
+// goroutines at a time. It returns the first error encountered, and as soon
+// as one jobFunc call returns an error, ctx passed to the remaining in-flight
+// calls is cancelled so they can abort early instead of doing wasted work.
+// It also returns promptly if ctx is cancelled externally, without blocking
+// on jobFunc calls that haven't started yet.
+func ForEachJob(ctx context.Context, jobs, concurrency int, jobFunc func(ctx context.Context, idx int) error) error {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if jobs < concurrency {
+		concurrency = jobs
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	indexes := make(chan int)
+
+	g.Go(func() error {
+		defer close(indexes)
+		for idx := 0; idx < jobs; idx++ {
+			select {
+			case indexes <- idx:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < concurrency; i++ {
+		g.Go(func() error {
+			for idx := range indexes {
+				if err := jobFunc(ctx, idx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
+}